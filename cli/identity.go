@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/goinp/goinp"
+	"github.com/bitrise-tools/codesigndoc/osxkeychain"
+)
+
+// disambiguateIdentity picks a single IdentityInfo out of matches - a
+// Keychain Label is not unique (WWDR-signed certificates frequently
+// collide), so more than one match is expected, not an error. In CI mode
+// (or once --identity-sha1 is set) disambiguation happens by fingerprint;
+// interactively, the user is prompted to pick one.
+func disambiguateIdentity(config ScanConfig, matches []osxkeychain.IdentityInfo) (osxkeychain.IdentityInfo, error) {
+	if len(matches) == 1 {
+		return matches[0], nil
+	}
+
+	if config.IdentitySHA1 != "" {
+		for _, m := range matches {
+			if strings.EqualFold(m.SHA1Fingerprint, config.IdentitySHA1) {
+				return m, nil
+			}
+		}
+		return osxkeychain.IdentityInfo{}, fmt.Errorf("no matching Identity found for --%s=%s", IdentitySHA1ParamKey, config.IdentitySHA1)
+	}
+
+	if config.IsCI {
+		return osxkeychain.IdentityInfo{}, fmt.Errorf("multiple matching Identities found in Keychain - disambiguate with --%s=<SHA-1 fingerprint>", IdentitySHA1ParamKey)
+	}
+
+	options := make([]string, len(matches))
+	for i, m := range matches {
+		options[i] = fmt.Sprintf("%s (SHA-1: %s, expires: %s)", m.Label, m.SHA1Fingerprint, m.NotAfter.Format("2006-01-02"))
+	}
+	selectedOption, err := goinp.SelectFromStrings("Multiple matching Identities found in Keychain - which one should be used?", options)
+	if err != nil {
+		return osxkeychain.IdentityInfo{}, fmt.Errorf("failed to select Identity: %s", err)
+	}
+	for i, option := range options {
+		if option == selectedOption {
+			return matches[i], nil
+		}
+	}
+	return osxkeychain.IdentityInfo{}, errors.New("failed to resolve the selected Identity")
+}
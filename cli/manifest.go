@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/bitrise-tools/codesigndoc/osxkeychain"
+	"github.com/bitrise-tools/codesigndoc/provprofile"
+	"github.com/bitrise-tools/codesigndoc/xcode"
+)
+
+const (
+	manifestJSONFileName = "manifest.json"
+	manifestYAMLFileName = "manifest.yml"
+
+	// toolVersion is reported in the export manifest, so downstream
+	// automation can tell which codesigndoc produced it.
+	toolVersion = "1.1.0"
+)
+
+// manifestProfile is the export manifest's per-provisioning-profile entry.
+type manifestProfile struct {
+	FileName             string                 `json:"file_name" yaml:"file_name"`
+	UUID                 string                 `json:"uuid" yaml:"uuid"`
+	Name                 string                 `json:"name" yaml:"name"`
+	TeamID               string                 `json:"team_id" yaml:"team_id"`
+	TeamName             string                 `json:"team_name" yaml:"team_name"`
+	AppID                string                 `json:"app_id" yaml:"app_id"`
+	ExpirationDate       string                 `json:"expiration_date" yaml:"expiration_date"`
+	ProvisionsAllDevices bool                   `json:"provisions_all_devices" yaml:"provisions_all_devices"`
+	DeviceCount          int                    `json:"device_count" yaml:"device_count"`
+	Entitlements         map[string]interface{} `json:"entitlements" yaml:"entitlements"`
+}
+
+func newManifestProfile(info provprofile.ProfileInfo, fileName string) manifestProfile {
+	teamID := ""
+	if len(info.TeamIdentifier) > 0 {
+		teamID = info.TeamIdentifier[0]
+	}
+
+	return manifestProfile{
+		FileName:             fileName,
+		UUID:                 info.UUID,
+		Name:                 info.Name,
+		TeamID:               teamID,
+		TeamName:             info.TeamName,
+		AppID:                info.ApplicationIdentifier,
+		ExpirationDate:       info.ExpirationDate.Format(time.RFC3339),
+		ProvisionsAllDevices: info.ProvisionsAllDevices,
+		DeviceCount:          len(info.ProvisionedDevices),
+		Entitlements:         info.Entitlements,
+	}
+}
+
+// manifestIdentity is the export manifest's per-exported-identity entry.
+type manifestIdentity struct {
+	CommonName        string `json:"common_name" yaml:"common_name"`
+	Issuer            string `json:"issuer" yaml:"issuer"`
+	SHA1Fingerprint   string `json:"sha1_fingerprint" yaml:"sha1_fingerprint"`
+	SHA256Fingerprint string `json:"sha256_fingerprint" yaml:"sha256_fingerprint"`
+	NotBefore         string `json:"not_before" yaml:"not_before"`
+	NotAfter          string `json:"not_after" yaml:"not_after"`
+}
+
+func newManifestIdentity(info osxkeychain.IdentityInfo) manifestIdentity {
+	return manifestIdentity{
+		CommonName:        info.CommonName,
+		Issuer:            info.Issuer,
+		SHA1Fingerprint:   info.SHA1Fingerprint,
+		SHA256Fingerprint: info.SHA256Fingerprint,
+		NotBefore:         info.NotBefore.Format(time.RFC3339),
+		NotAfter:          info.NotAfter.Format(time.RFC3339),
+	}
+}
+
+// exportManifest is the top-level document written as manifest.json/manifest.yml,
+// describing every artifact produced by an export, so downstream automation
+// doesn't have to re-parse the binary Identities.p12 / .mobileprovision files.
+type exportManifest struct {
+	CodesigndocVersion   string             `json:"codesigndoc_version" yaml:"codesigndoc_version"`
+	XcodeProject         string             `json:"xcode_project" yaml:"xcode_project"`
+	Scheme               string             `json:"scheme" yaml:"scheme"`
+	GeneratedAt          string             `json:"generated_at" yaml:"generated_at"`
+	Identities           []manifestIdentity `json:"identities" yaml:"identities"`
+	ProvisioningProfiles []manifestProfile  `json:"provisioning_profiles" yaml:"provisioning_profiles"`
+}
+
+// writeManifest writes manifest.json and manifest.yml into absExportOutputDirPath,
+// summarizing the exported identities and provisioning profiles.
+func writeManifest(config ScanConfig, xcodeCmd xcode.CommandModel, absExportOutputDirPath string, profiles []manifestProfile, exportedIdentities []osxkeychain.IdentityInfo) error {
+	identities := make([]manifestIdentity, 0, len(exportedIdentities))
+	for _, info := range exportedIdentities {
+		identities = append(identities, newManifestIdentity(info))
+	}
+
+	manifest := exportManifest{
+		CodesigndocVersion:   toolVersion,
+		XcodeProject:         xcodeCmd.ProjectFilePath,
+		Scheme:               xcodeCmd.Scheme,
+		GeneratedAt:          time.Now().Format(time.RFC3339),
+		Identities:           identities,
+		ProvisioningProfiles: profiles,
+	}
+
+	jsonBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(absExportOutputDirPath, manifestJSONFileName), jsonBytes, 0644); err != nil {
+		return err
+	}
+
+	yamlBytes, err := yaml.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(absExportOutputDirPath, manifestYAMLFileName), yamlBytes, 0644)
+}
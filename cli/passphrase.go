@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/bitrise-io/go-utils/colorstring"
+	"github.com/bitrise-io/goinp/goinp"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	generatedPassphraseLength   = 32
+	generatedPassphraseFileName = "Identities.p12.passphrase"
+	passphraseAlphabet          = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+)
+
+// resolveExportPassphrase picks the passphrase to protect the exported
+// Identities.p12 with, in order of precedence: an explicit --export-passphrase,
+// a --export-passphrase-file, a --generate-passphrase, then (outside CI, when
+// attached to a terminal) an interactive prompt. If none apply it falls back
+// to an empty passphrase, which ExportFromKeychain will loudly warn about.
+func resolveExportPassphrase(config ScanConfig, absExportOutputDirPath string) (string, error) {
+	if config.ExportPassphrase != "" {
+		return config.ExportPassphrase, nil
+	}
+
+	if config.ExportPassphraseFile != "" {
+		return readPassphraseFile(config.ExportPassphraseFile)
+	}
+
+	if config.GeneratePassphrase {
+		return generateAndStorePassphrase(absExportOutputDirPath)
+	}
+
+	if !config.IsCI && terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return askForPassphrase()
+	}
+
+	return "", nil
+}
+
+func readPassphraseFile(filePth string) (string, error) {
+	f, err := os.Open(filePth)
+	if err != nil {
+		return "", fmt.Errorf("failed to open export passphrase file (%s): %s", filePth, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return "", fmt.Errorf("export passphrase file (%s) is empty", filePth)
+	}
+	return scanner.Text(), nil
+}
+
+func generatePassphrase(length int) (string, error) {
+	randomBytes := make([]byte, length)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", fmt.Errorf("failed to read random bytes: %s", err)
+	}
+
+	passphrase := make([]byte, length)
+	for i, b := range randomBytes {
+		passphrase[i] = passphraseAlphabet[int(b)%len(passphraseAlphabet)]
+	}
+	return string(passphrase), nil
+}
+
+func generateAndStorePassphrase(absExportOutputDirPath string) (string, error) {
+	passphrase, err := generatePassphrase(generatedPassphraseLength)
+	if err != nil {
+		return "", err
+	}
+
+	passphraseFilePth := path.Join(absExportOutputDirPath, generatedPassphraseFileName)
+	if err := ioutil.WriteFile(passphraseFilePth, []byte(passphrase), 0600); err != nil {
+		return "", fmt.Errorf("failed to write generated passphrase to file (%s): %s", passphraseFilePth, err)
+	}
+
+	fmt.Println()
+	fmt.Println(colorstring.Yellow("=== Generated Identities.p12 passphrase ==="))
+	fmt.Println(colorstring.Yellow(passphrase))
+	fmt.Println(colorstring.Yellow("============================================"))
+	log.Warnf("This passphrase is only printed once, but was also saved to: %s", passphraseFilePth)
+
+	return passphrase, nil
+}
+
+func askForPassphrase() (string, error) {
+	for {
+		passphrase, err := goinp.AskForString("Enter a Passphrase to protect the exported Identities.p12 with (leave empty for none):")
+		if err != nil {
+			return "", fmt.Errorf("failed to read Passphrase: %s", err)
+		}
+		if strings.TrimSpace(passphrase) == "" {
+			log.Warn("No Passphrase specified - the exported Identities.p12 will not be protected!")
+			return "", nil
+		}
+
+		confirmation, err := goinp.AskForString("Confirm the Passphrase:")
+		if err != nil {
+			return "", fmt.Errorf("failed to read Passphrase confirmation: %s", err)
+		}
+		if passphrase != confirmation {
+			log.Error("Passphrases don't match, please try again.")
+			continue
+		}
+		return passphrase, nil
+	}
+}
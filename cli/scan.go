@@ -1,9 +1,12 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path"
+	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 
@@ -20,23 +23,186 @@ import (
 
 const (
 	confExportOutputDirPath = "./codesigndoc_exports"
+
+	// profileExpiryWarningPeriod is how far ahead of a provisioning
+	// profile's expiration we start warning about it.
+	profileExpiryWarningPeriod = 60 * 24 * time.Hour
+
+	// FileParamKey is the flag holding the Xcode Project/Workspace path.
+	FileParamKey = "file"
+	// SchemeParamKey is the flag holding the Xcode Scheme to use.
+	SchemeParamKey = "scheme"
+	// ConfigurationParamKey is the flag holding the Xcode build Configuration to use.
+	ConfigurationParamKey = "configuration"
+	// OutputDirParamKey is the flag holding the export output directory path.
+	OutputDirParamKey = "output-dir"
+	// ExportPassphraseParamKey is the flag holding the passphrase to protect the exported .p12 with.
+	ExportPassphraseParamKey = "export-passphrase"
+	// ExportPassphraseFileParamKey is the flag holding the path of a file whose first line is the export passphrase.
+	ExportPassphraseFileParamKey = "export-passphrase-file"
+	// GeneratePassphraseParamKey is the flag requesting a random export passphrase be generated.
+	GeneratePassphraseParamKey = "generate-passphrase"
+	// ForceYesParamKey is the flag which auto-answers every yes/no prompt with yes.
+	ForceYesParamKey = "force-yes"
+	// OutputFormatParamKey is the flag selecting the "text" (default) or "json" output format.
+	OutputFormatParamKey = "output-format"
+	// CIParamKey is the flag which forces non-interactive CI mode, regardless of the environment.
+	CIParamKey = "ci"
+	// IdentitySHA1ParamKey is the flag disambiguating, by SHA-1 fingerprint, which Identity to use when a label matches more than one.
+	IdentitySHA1ParamKey = "identity-sha1"
+
+	outputFormatText = "text"
+	outputFormatJSON = "json"
+
+	// Exit codes returned in CI mode, so calling CI systems can branch on the failure reason.
+	exitCodeOK               = 0
+	exitCodeInvalidInput     = 2
+	exitCodeCodeSigningIssue = 3
+	exitCodeExportFailed     = 4
 )
 
+// ScanConfig captures every input to a scan run - whether it came from an
+// interactive prompt or from CLI flags/CI env vars - so the interactive and
+// CI code paths can both funnel through Run.
+type ScanConfig struct {
+	ProjectPath          string
+	Scheme               string
+	Configuration        string
+	OutputDirPath        string
+	ExportPassphrase     string
+	ExportPassphraseFile string
+	GeneratePassphrase   bool
+	IdentitySHA1         string
+	ForceYes             bool
+	OutputFormat         string
+	IsCI                 bool
+}
+
+// exportedProfileResult is the JSON-serializable summary of a single
+// exported provisioning profile.
+type exportedProfileResult struct {
+	UUID string `json:"uuid"`
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// scanResult is the structured, JSON-serializable outcome of a scan run.
+type scanResult struct {
+	IdentitiesExportPath string                  `json:"identities_export_path,omitempty"`
+	ExportedProfiles     []exportedProfileResult `json:"exported_profiles,omitempty"`
+	Warnings             []string                `json:"warnings,omitempty"`
+	Errors               []string                `json:"errors,omitempty"`
+}
+
 func printFinished() {
 	fmt.Println()
 	fmt.Println(colorstring.Green("That's all."))
 	fmt.Println("You just have to upload the found code signing files and you'll be good to go!")
 }
 
+func printProfileExpiry(profileInfo provprofile.ProfileInfo) {
+	expiryText := fmt.Sprintf("  Team: %s | Expires: %s", profileInfo.TeamName, profileInfo.ExpirationDate.Format("2006-01-02"))
+	switch {
+	case profileInfo.IsExpired():
+		log.Warn(colorstring.Red(expiryText + " (EXPIRED)"))
+	case profileInfo.ExpiresWithin(profileExpiryWarningPeriod):
+		log.Warn(colorstring.Yellow(expiryText + " (expiring soon)"))
+	default:
+		log.Info(expiryText)
+	}
+}
+
+// checkIdentityMatch verifies that at least one of identitySHA1Fingerprints
+// matches a Developer Certificate embedded in profileInfo.
+func checkIdentityMatch(profileInfo provprofile.ProfileInfo, identitySHA1Fingerprints []string) error {
+	certs, err := profileInfo.Certificates()
+	if err != nil {
+		return fmt.Errorf("failed to check Developer Certificates of Provisioning Profile (%s): %s", profileInfo.Name, err)
+	}
+
+	for _, fingerprint := range identitySHA1Fingerprints {
+		for _, cert := range certs {
+			if strings.EqualFold(cert.SHA1Fingerprint, fingerprint) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("none of the Identities exported from your Keychain match a Developer Certificate embedded in Provisioning Profile: %s (UUID: %s) - you most likely have the wrong certificate in your Keychain", profileInfo.Name, profileInfo.UUID)
+}
+
+// isCIMode reports whether the tool should run non-interactively: either
+// because --ci was passed explicitly, or because the environment matches
+// the usual Bitrise/CI convention.
+func isCIMode(c *cli.Context) bool {
+	if c.Bool(CIParamKey) {
+		return true
+	}
+	return os.Getenv("CI") == "true" || os.Getenv("BITRISE_CI") == "true"
+}
+
 func scan(c *cli.Context) {
-	projectPath := c.String(FileParamKey)
+	outputFormat := c.String(OutputFormatParamKey)
+	if outputFormat == "" {
+		outputFormat = outputFormatText
+	}
+	outputDirPath := c.String(OutputDirParamKey)
+	if outputDirPath == "" {
+		outputDirPath = confExportOutputDirPath
+	}
+
+	config := ScanConfig{
+		ProjectPath:          c.String(FileParamKey),
+		Scheme:               c.String(SchemeParamKey),
+		Configuration:        c.String(ConfigurationParamKey),
+		OutputDirPath:        outputDirPath,
+		ExportPassphrase:     c.String(ExportPassphraseParamKey),
+		ExportPassphraseFile: c.String(ExportPassphraseFileParamKey),
+		GeneratePassphrase:   c.Bool(GeneratePassphraseParamKey),
+		IdentitySHA1:         c.String(IdentitySHA1ParamKey),
+		ForceYes:             c.Bool(ForceYesParamKey),
+		OutputFormat:         outputFormat,
+		IsCI:                 isCIMode(c),
+	}
+
+	Run(config)
+}
+
+// Run executes a full scan+export, either interactively (prompting for any
+// missing input) or non-interactively in CI mode (failing fast, with a
+// specific exit code, if a required input is missing). On exit it reports
+// its outcome either as human-readable text or - when config.OutputFormat
+// is "json" - as a single JSON document on stdout.
+func Run(config ScanConfig) {
+	result := &scanResult{}
+
+	fail := func(exitCode int, format string, args ...interface{}) {
+		message := fmt.Sprintf(format, args...)
+		if !config.IsCI {
+			log.Fatalf("%s", message)
+		}
+		result.Errors = append(result.Errors, message)
+		printResult(config, result)
+		os.Exit(exitCode)
+	}
+	warn := func(format string, args ...interface{}) {
+		message := fmt.Sprintf(format, args...)
+		log.Warn(message)
+		result.Warnings = append(result.Warnings, message)
+	}
+
+	projectPath := config.ProjectPath
 	if projectPath == "" {
+		if config.IsCI {
+			fail(exitCodeInvalidInput, "No Xcode Project/Workspace path specified - pass it with --%s", FileParamKey)
+		}
 		askText := `Please drag-and-drop your Xcode Project (` + colorstring.Green(".xcodeproj") + `)
    or Workspace (` + colorstring.Green(".xcworkspace") + `) file, the one you usually open in Xcode,
    then hit Enter.
 
   (Note: if you have a Workspace file you should most likely use that)`
-		fmt.Println()
+		if config.OutputFormat != outputFormatJSON {
+			fmt.Println()
+		}
 		projpth, err := goinp.AskForString(askText)
 		if err != nil {
 			log.Fatalf("Failed to read input: %s", err)
@@ -46,138 +212,207 @@ func scan(c *cli.Context) {
 	log.Debugf("projectPath: %s", projectPath)
 	xcodeCmd := xcode.CommandModel{
 		ProjectFilePath: projectPath,
+		Configuration:   config.Configuration,
 	}
 
-	schemeToUse := c.String(SchemeParamKey)
+	schemeToUse := config.Scheme
 	if schemeToUse == "" {
 		log.Println("🔦  Scanning Schemes ...")
 		schemes, err := xcodeCmd.ScanSchemes()
 		if err != nil {
-			log.Fatalf("Failed to scan Schemes: %s", err)
+			fail(exitCodeInvalidInput, "Failed to scan Schemes: %s", err)
 		}
 		log.Debugf("schemes: %v", schemes)
 
-		fmt.Println()
-		selectedScheme, err := goinp.SelectFromStrings("Select the Scheme you usually use in Xcode", schemes)
-		if err != nil {
-			log.Fatalf("Failed to select Scheme: %s", err)
+		switch {
+		case len(schemes) == 1 && config.IsCI:
+			schemeToUse = schemes[0]
+			log.Debugf("single scheme found, auto-selecting: %v", schemeToUse)
+		case config.IsCI:
+			fail(exitCodeInvalidInput, "Multiple Schemes found (%v) - pass the one to use with --%s", schemes, SchemeParamKey)
+		default:
+			if config.OutputFormat != outputFormatJSON {
+				fmt.Println()
+			}
+			selectedScheme, err := goinp.SelectFromStrings("Select the Scheme you usually use in Xcode", schemes)
+			if err != nil {
+				log.Fatalf("Failed to select Scheme: %s", err)
+			}
+			log.Debugf("selected scheme: %v", selectedScheme)
+			schemeToUse = selectedScheme
 		}
-		log.Debugf("selected scheme: %v", selectedScheme)
-		schemeToUse = selectedScheme
 	}
 	xcodeCmd.Scheme = schemeToUse
 
-	fmt.Println()
+	if config.OutputFormat != outputFormatJSON {
+		fmt.Println()
+	}
 	log.Println("🔦  Running an Xcode Archive, to get all the required code signing settings...")
 	codeSigningSettings, err := xcodeCmd.ScanCodeSigningSettings()
 	if err != nil {
-		log.Fatalf("Failed to detect code signing settings: %s", err)
+		fail(exitCodeInvalidInput, "Failed to detect code signing settings: %s", err)
 	}
 	log.Debugf("codeSigningSettings: %#v", codeSigningSettings)
 
-	fmt.Println()
-	utils.Printlnf("=== Required Identities/Certificates (%d) ===", len(codeSigningSettings.Identities))
-	for idx, anIdentity := range codeSigningSettings.Identities {
-		utils.Printlnf(" * (%d): %s", idx+1, anIdentity.Title)
-	}
-	fmt.Println("========================================")
+	if config.OutputFormat != outputFormatJSON {
+		fmt.Println()
+		utils.Printlnf("=== Required Identities/Certificates (%d) ===", len(codeSigningSettings.Identities))
+		for idx, anIdentity := range codeSigningSettings.Identities {
+			utils.Printlnf(" * (%d): %s", idx+1, anIdentity.Title)
+		}
+		fmt.Println("========================================")
 
-	fmt.Println()
-	utils.Printlnf("=== Required Provisioning Profiles (%d) ===", len(codeSigningSettings.ProvProfiles))
-	for idx, aProvProfile := range codeSigningSettings.ProvProfiles {
-		utils.Printlnf(" * (%d): %s (UUID: %s)", idx+1, aProvProfile.Title, aProvProfile.UUID)
+		fmt.Println()
+		utils.Printlnf("=== Required Provisioning Profiles (%d) ===", len(codeSigningSettings.ProvProfiles))
+		for idx, aProvProfile := range codeSigningSettings.ProvProfiles {
+			utils.Printlnf(" * (%d): %s (UUID: %s)", idx+1, aProvProfile.Title, aProvProfile.UUID)
+		}
+		fmt.Println("======================================")
 	}
-	fmt.Println("======================================")
 
 	//
 	// --- Code Signing issue checks / report
 	//
 
 	if len(codeSigningSettings.Identities) < 1 {
-		log.Fatal("No Code Signing Identity detected!")
+		fail(exitCodeCodeSigningIssue, "No Code Signing Identity detected!")
 	}
 	if len(codeSigningSettings.Identities) > 1 {
-		log.Warning("More than one Code Signing Identity (certificate) is required to sign your app!")
-		log.Warning("You should check your settings and make sure a single Identity/Certificate can be used")
-		log.Warning(" for Archiving your app!")
+		warn("More than one Code Signing Identity (certificate) is required to sign your app! You should check your settings and make sure a single Identity/Certificate can be used for Archiving your app!")
 	}
 
 	if len(codeSigningSettings.ProvProfiles) < 1 {
-		log.Fatal("No Provisioning Profiles detected!")
+		fail(exitCodeCodeSigningIssue, "No Provisioning Profiles detected!")
 	}
 
 	//
 	// --- Export
 	//
 
-	isShouldExport, err := goinp.AskForBool("Do you want to export these files?")
-	if err != nil {
-		log.Fatalf("Failed to process your input: %s", err)
-	}
-	if !isShouldExport {
-		printFinished()
-		return
+	if !config.IsCI && !config.ForceYes {
+		isShouldExport, err := goinp.AskForBool("Do you want to export these files?")
+		if err != nil {
+			log.Fatalf("Failed to process your input: %s", err)
+		}
+		if !isShouldExport {
+			if config.OutputFormat != outputFormatJSON {
+				printFinished()
+			}
+			return
+		}
 	}
 
-	fmt.Println()
+	if config.OutputFormat != outputFormatJSON {
+		fmt.Println()
+	}
 	log.Println("Exporting the required Identities (Certificates) ...")
-	fmt.Println(" You'll most likely see popups (one for each Identity) from Keychain,")
-	fmt.Println(" you will have to accept (Allow) those to be able to export the Identity")
-	fmt.Println()
+	log.Println(" You'll most likely see popups (one for each Identity) from Keychain,")
+	log.Println(" you will have to accept (Allow) those to be able to export the Identity")
 
-	absExportOutputDirPath, err := pathutil.AbsPath(confExportOutputDirPath)
+	absExportOutputDirPath, err := pathutil.AbsPath(config.OutputDirPath)
 	log.Debugf("absExportOutputDirPath: %s", absExportOutputDirPath)
 	if err != nil {
-		log.Fatalf("Failed to determin Absolute path of export dir: %s", confExportOutputDirPath)
+		fail(exitCodeExportFailed, "Failed to determin Absolute path of export dir: %s", config.OutputDirPath)
 	}
 	if exist, err := pathutil.IsDirExists(absExportOutputDirPath); err != nil {
-		log.Fatalf("Failed to determin whether the export directory already exists: %s", err)
+		fail(exitCodeExportFailed, "Failed to determin whether the export directory already exists: %s", err)
 	} else if !exist {
 		if err := os.Mkdir(absExportOutputDirPath, 0777); err != nil {
-			log.Fatalf("Failed to create export output directory at path: %s | error: %s", absExportOutputDirPath, err)
+			fail(exitCodeExportFailed, "Failed to create export output directory at path: %s | error: %s", absExportOutputDirPath, err)
 		}
 	} else {
 		log.Debugf("Export output dir already exists at path: %s", absExportOutputDirPath)
 	}
 
+	passphrase, err := resolveExportPassphrase(config, absExportOutputDirPath)
+	if err != nil {
+		fail(exitCodeInvalidInput, "Failed to determine export Passphrase: %s", err)
+	}
+
 	identityExportRefs := osxkeychain.CreateEmptyCFTypeRefSlice()
 	defer osxkeychain.ReleaseRefList(identityExportRefs)
 
-	fmt.Println()
+	identitySHA1Fingerprints := []string{}
+	exportedIdentities := []osxkeychain.IdentityInfo{}
+
+	if config.OutputFormat != outputFormatJSON {
+		fmt.Println()
+	}
 	for _, aIdentity := range codeSigningSettings.Identities {
 		log.Infof(" * Exporting Identity: %s", aIdentity.Title)
-		identityRefs, err := osxkeychain.FindIdentity(aIdentity.Title)
+		matches, err := osxkeychain.FindIdentity(osxkeychain.IdentityMatch{Label: aIdentity.Title})
 		if err != nil {
-			log.Fatalf("Failed to Export Identity: %s", err)
+			fail(exitCodeExportFailed, "Failed to Export Identity: %s", err)
 		}
-		log.Debugf("identityRefs: %d", len(identityRefs))
-		if len(identityRefs) < 1 {
-			log.Fatalf("No Identity found in Keychain!")
+		log.Debugf("matching identities: %d", len(matches))
+
+		selected, err := disambiguateIdentity(config, matches)
+		if err != nil {
+			osxkeychain.ReleaseIdentities(matches)
+			fail(exitCodeInvalidInput, "%s", err)
 		}
-		if len(identityRefs) > 1 {
-			log.Fatalf("Multiple matching Identities found in Keychain! Most likely you have duplicate identity in separate Keychains, like one in System.keychain and one in your Login.keychain")
+		for _, m := range matches {
+			if m.Ref != selected.Ref {
+				osxkeychain.ReleaseRef(m.Ref)
+			}
 		}
-		identityExportRefs = append(identityExportRefs, identityRefs...)
+
+		identityExportRefs = append(identityExportRefs, selected.Ref)
+		identitySHA1Fingerprints = append(identitySHA1Fingerprints, selected.SHA1Fingerprint)
+		exportedIdentities = append(exportedIdentities, selected)
 	}
 
-	if err := osxkeychain.ExportFromKeychain(identityExportRefs, path.Join(absExportOutputDirPath, "Identities.p12")); err != nil {
-		log.Fatalf("Failed to export from Keychain: %s", err)
+	identitiesExportPath := path.Join(absExportOutputDirPath, "Identities.p12")
+	if err := osxkeychain.ExportFromKeychain(identityExportRefs, identitiesExportPath, passphrase); err != nil {
+		fail(exitCodeExportFailed, "Failed to export from Keychain: %s", err)
 	}
+	result.IdentitiesExportPath = identitiesExportPath
 
-	fmt.Println()
+	manifestProfiles := []manifestProfile{}
+
+	if config.OutputFormat != outputFormatJSON {
+		fmt.Println()
+	}
 	for _, aProvProfile := range codeSigningSettings.ProvProfiles {
 		log.Infof(" * Exporting Provisioning Profile: %s (UUID: %s)", aProvProfile.Title, aProvProfile.UUID)
 		filePth, err := provprofile.FindProvProfileFile(aProvProfile)
 		if err != nil {
-			log.Fatalf("Failed to find Provisioning Profile: %s", err)
+			fail(exitCodeExportFailed, "Failed to find Provisioning Profile: %s", err)
 		}
 		log.Infof("  File found at: %s", filePth)
 
+		profileInfo, err := provprofile.ParseProfile(filePth)
+		if err != nil {
+			fail(exitCodeExportFailed, "Failed to parse Provisioning Profile (%s): %s", filePth, err)
+		}
+
+		if config.OutputFormat != outputFormatJSON {
+			printProfileExpiry(profileInfo)
+		} else if profileInfo.IsExpired() || profileInfo.ExpiresWithin(profileExpiryWarningPeriod) {
+			warn("Provisioning Profile %s (UUID: %s) expires on %s", profileInfo.Name, profileInfo.UUID, profileInfo.ExpirationDate.Format("2006-01-02"))
+		}
+
+		if err := checkIdentityMatch(profileInfo, identitySHA1Fingerprints); err != nil {
+			fail(exitCodeCodeSigningIssue, "%s", err)
+		}
+
+		exportedProfilePath := path.Join(absExportOutputDirPath, path.Base(filePth))
 		cmdex.RunCommandAndReturnCombinedStdoutAndStderr("cp", filePth, absExportOutputDirPath+"/")
+		result.ExportedProfiles = append(result.ExportedProfiles, exportedProfileResult{
+			UUID: profileInfo.UUID,
+			Name: profileInfo.Name,
+			Path: exportedProfilePath,
+		})
+		manifestProfiles = append(manifestProfiles, newManifestProfile(profileInfo, path.Base(filePth)))
+	}
+
+	if err := writeManifest(config, xcodeCmd, absExportOutputDirPath, manifestProfiles, exportedIdentities); err != nil {
+		warn("Failed to write export manifest: %s", err)
+	}
 
-		// if err := provprofile.PrintFileInfo(filePth); err != nil {
-		// 	log.Fatalf("Err: %s", err)
-		// }
+	if config.OutputFormat == outputFormatJSON {
+		printResult(config, result)
+		return
 	}
 
 	fmt.Println()
@@ -189,4 +424,15 @@ func scan(c *cli.Context) {
 	fmt.Println()
 
 	printFinished()
-}
\ No newline at end of file
+}
+
+func printResult(config ScanConfig, result *scanResult) {
+	if config.OutputFormat != outputFormatJSON {
+		return
+	}
+	jsonBytes, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		log.Fatalf("Failed to marshal scan result to JSON: %s", err)
+	}
+	fmt.Println(string(jsonBytes))
+}
@@ -0,0 +1,31 @@
+package cli
+
+import (
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/bitrise-tools/codesigndoc/signserver"
+	"github.com/codegangsta/cli"
+)
+
+// SocketPathParamKey overrides the signing proxy's default Unix socket path.
+const SocketPathParamKey = "socket"
+
+// serve starts the Keychain-backed signing proxy ("codesigndoc serve"), an
+// opt-in alternative to exporting Identities.p12 for environments that
+// don't allow private keys to leave the machine. The regular `scan` export
+// flow remains the default.
+func serve(c *cli.Context) {
+	socketPath := c.String(SocketPathParamKey)
+	if socketPath == "" {
+		defaultSocketPath, err := signserver.DefaultSocketPath()
+		if err != nil {
+			log.Fatalf("Failed to determine default socket path: %s", err)
+		}
+		socketPath = defaultSocketPath
+	}
+
+	log.Infof("Starting codesigndoc signing proxy - private keys stay in the Keychain, nothing is exported to disk.")
+	if err := signserver.Serve(socketPath); err != nil {
+		log.Fatalf("Signing proxy failed: %s", err)
+	}
+}
@@ -3,6 +3,7 @@ package osxkeychain
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"unsafe"
 
 	log "github.com/Sirupsen/logrus"
@@ -18,11 +19,19 @@ import (
 */
 import "C"
 
-// ExportFromKeychain ...
-func ExportFromKeychain(itemRefsToExport []C.CFTypeRef, outputFilePath string) error {
-	log.Info("Exporting from Keychain, using empty Passphrase ...")
+// ExportFromKeychain exports itemRefsToExport into a PKCS12 (.p12) file at
+// outputFilePath, protected with passphrase. An empty passphrase is still
+// accepted, for backwards compatibility, but callers should prefer
+// generating or asking for a real one - a .p12 with no passphrase is
+// trivially usable by anyone who gets hold of the file.
+func ExportFromKeychain(itemRefsToExport []C.CFTypeRef, outputFilePath string, passphrase string) error {
+	if passphrase == "" {
+		log.Warn("Exporting from Keychain with an EMPTY Passphrase - the exported Identities.p12 will not be protected!")
+	} else {
+		log.Info("Exporting from Keychain ...")
+	}
 
-	passphraseCString := C.CString("")
+	passphraseCString := C.CString(passphrase)
 	defer C.free(unsafe.Pointer(passphraseCString))
 
 	var exportedData C.CFDataRef
@@ -86,69 +95,65 @@ func CreateEmptyCFTypeRefSlice() []C.CFTypeRef {
 	return []C.CFTypeRef{}
 }
 
-// FindIdentity ...
-//  IMPORTANT: you have to C.CFRelease the returned items (one-by-one)!!
-//             you can use the ReleaseRefList method to do that
-func FindIdentity(identityLabel string) ([]C.CFTypeRef, error) {
-
-	queryDict := C.CFDictionaryCreateMutable(nil, 0, nil, nil)
-	defer C.CFRelease(C.CFTypeRef(queryDict))
-	C.CFDictionaryAddValue(queryDict, unsafe.Pointer(C.kSecClass), unsafe.Pointer(C.kSecClassIdentity))
-	C.CFDictionaryAddValue(queryDict, unsafe.Pointer(C.kSecMatchLimit), unsafe.Pointer(C.kSecMatchLimitAll))
-	C.CFDictionaryAddValue(queryDict, unsafe.Pointer(C.kSecReturnAttributes), unsafe.Pointer(C.kCFBooleanTrue))
-	C.CFDictionaryAddValue(queryDict, unsafe.Pointer(C.kSecReturnRef), unsafe.Pointer(C.kCFBooleanTrue))
-
-	var resultRefs C.CFTypeRef
-	osStatusCode := C.SecItemCopyMatching(queryDict, &resultRefs)
-	if osStatusCode != C.errSecSuccess {
-		return nil, fmt.Errorf("Failed to call SecItemCopyMatch - OSStatus: %d", osStatusCode)
-	}
-	defer C.CFRelease(C.CFTypeRef(resultRefs))
-
-	identitiesArrRef := C.CFArrayRef(resultRefs)
-	identitiesCount := C.CFArrayGetCount(identitiesArrRef)
-	if identitiesCount < 1 {
-		return nil, fmt.Errorf("No Identity found in your Keychain with the specified Label!")
-	}
-	log.Debugf("identitiesCount: %d", identitiesCount)
-
-	// filter the identities, by label
-	retIdentityRefs := []C.CFTypeRef{}
-	for i := identitiesCount - 1; i > 0; i-- {
-		aIdentityRef := C.CFArrayGetValueAtIndex(identitiesArrRef, i)
-		log.Debugf("aIdentityRef: %#v", aIdentityRef)
-		aIdentityDictRef := C.CFDictionaryRef(aIdentityRef)
-		log.Debugf("aIdentityDictRef: %#v", aIdentityDictRef)
-
-		lablCSting := C.CString("labl")
-		defer C.free(unsafe.Pointer(lablCSting))
-		vrefCSting := C.CString("v_Ref")
-		defer C.free(unsafe.Pointer(vrefCSting))
-
-		labl, err := getCFDictValueUTF8String(aIdentityDictRef, C.CFTypeRef(convertCStringToCFString(lablCSting)))
-		if err != nil {
-			return nil, fmt.Errorf("FindIdentity: failed to get 'labl' property: %s", err)
+// IdentityMatch selects which Keychain Identities FindIdentity should
+// return. At least one of Label or SHA1Fingerprint must be set; when both
+// are set, an Identity must match both. Pairing Label with SHA1Fingerprint
+// disambiguates identities whose labels collide - which happens often,
+// since WWDR-signed certificates frequently share a label.
+type IdentityMatch struct {
+	Label           string
+	SHA1Fingerprint string
+}
+
+// FindIdentity returns every Keychain Identity matching match.
+//  IMPORTANT: you have to C.CFRelease the returned items' Ref (one-by-one)!!
+//             you can use ReleaseIdentities to do that.
+func FindIdentity(match IdentityMatch) ([]IdentityInfo, error) {
+	if match.Label == "" && match.SHA1Fingerprint == "" {
+		return nil, errors.New("FindIdentity: at least one of Label or SHA1Fingerprint must be set")
+	}
+
+	identities, err := ListIdentities()
+	if err != nil {
+		return nil, err
+	}
+
+	matchingIdentities := []IdentityInfo{}
+	for _, identity := range identities {
+		if match.Label != "" && identity.Label != match.Label {
+			ReleaseRef(identity.Ref)
+			continue
 		}
-		log.Debugf("labl: %#v", labl)
-		if labl != identityLabel {
+		if match.SHA1Fingerprint != "" && !strings.EqualFold(identity.SHA1Fingerprint, match.SHA1Fingerprint) {
+			ReleaseRef(identity.Ref)
 			continue
 		}
-		log.Debugf("Found identity with label: %s", labl)
+		matchingIdentities = append(matchingIdentities, identity)
+	}
 
-		vrefRef, err := getCFDictValueRef(aIdentityDictRef, C.CFTypeRef(convertCStringToCFString(vrefCSting)))
-		if err != nil {
-			return nil, fmt.Errorf("FindIdentity: failed to get 'v_Ref' property: %s", err)
-		}
-		log.Debugf("vrefRef: %#v", vrefRef)
+	if len(matchingIdentities) < 1 {
+		return nil, fmt.Errorf("No Identity found in your Keychain matching Label: %q, SHA1Fingerprint: %q", match.Label, match.SHA1Fingerprint)
+	}
+	return matchingIdentities, nil
+}
+
+// certificateDER returns the DER encoding of the X.509 certificate backing
+// the given Identity CFTypeRef.
+func certificateDER(identityRef C.CFTypeRef) ([]byte, error) {
+	var certRef C.SecCertificateRef
+	status := C.SecIdentityCopyCertificate(C.SecIdentityRef(unsafe.Pointer(identityRef)), &certRef)
+	if status != C.errSecSuccess {
+		return nil, fmt.Errorf("SecIdentityCopyCertificate: error (OSStatus): %d", status)
+	}
+	defer C.CFRelease(C.CFTypeRef(certRef))
 
-		// retain the pointer
-		vrefRef = C.CFRetain(vrefRef)
-		// store it
-		retIdentityRefs = append(retIdentityRefs, vrefRef)
+	derDataRef := C.SecCertificateCopyData(certRef)
+	if derDataRef == nil {
+		return nil, errors.New("certificateDER: failed to copy certificate data")
 	}
+	defer C.CFRelease(C.CFTypeRef(derDataRef))
 
-	fmt.Println("-- DONE --")
-	return retIdentityRefs, nil
+	return C.GoBytes(unsafe.Pointer(C.CFDataGetBytePtr(derDataRef)), (C.int)(C.CFDataGetLength(derDataRef))), nil
 }
 
 //
@@ -0,0 +1,186 @@
+package osxkeychain
+
+/*
+#cgo CFLAGS: -mmacosx-version-min=10.7 -D__MAC_OS_X_VERSION_MAX_ALLOWED=1060
+#cgo LDFLAGS: -framework CoreFoundation -framework Security
+#include <stdlib.h>
+#include <CoreFoundation/CoreFoundation.h>
+#include <Security/Security.h>
+*/
+import "C"
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+	"unsafe"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+// newTestKeychain creates a throwaway Keychain file so tests never touch a
+// developer's real login/system Keychain, and returns a cleanup func.
+func newTestKeychain(t *testing.T) (C.SecKeychainRef, func()) {
+	t.Helper()
+
+	pathCString := C.CString(fmt.Sprintf("%s/codesigndoc-test-%d.keychain", os.TempDir(), time.Now().UnixNano()))
+	defer C.free(unsafe.Pointer(pathCString))
+	passwordCString := C.CString("codesigndoc-test")
+	defer C.free(unsafe.Pointer(passwordCString))
+
+	var keychainRef C.SecKeychainRef
+	status := C.SecKeychainCreate(pathCString, C.UInt32(len("codesigndoc-test")), unsafe.Pointer(passwordCString), C.Boolean(0), nil, &keychainRef)
+	if status != C.errSecSuccess {
+		t.Fatalf("SecKeychainCreate: error (OSStatus): %d", status)
+	}
+
+	// SecKeychainCreate does not add the new Keychain to the default search
+	// list, and SecItemCopyMatching (which ListIdentities/FindIdentity use)
+	// only searches that list - without this, the Identity imported into
+	// keychainRef would never actually be found by the code under test.
+	restoreSearchList := addToDefaultSearchList(t, keychainRef)
+
+	return keychainRef, func() {
+		restoreSearchList()
+		C.SecKeychainDelete(keychainRef)
+		C.CFRelease(C.CFTypeRef(keychainRef))
+	}
+}
+
+// addToDefaultSearchList prepends keychainRef to the process's Keychain
+// search list, saving the prior list so it can be restored. Returns a
+// restore func that callers must invoke once done (typically via defer).
+func addToDefaultSearchList(t *testing.T, keychainRef C.SecKeychainRef) func() {
+	t.Helper()
+
+	var originalListRef C.CFArrayRef
+	status := C.SecKeychainCopySearchList(&originalListRef)
+	if status != C.errSecSuccess {
+		t.Fatalf("SecKeychainCopySearchList: error (OSStatus): %d", status)
+	}
+
+	originalCount := C.CFArrayGetCount(originalListRef)
+	newList := make([]unsafe.Pointer, 0, originalCount+1)
+	newList = append(newList, unsafe.Pointer(keychainRef))
+	for i := C.CFIndex(0); i < originalCount; i++ {
+		newList = append(newList, unsafe.Pointer(C.CFArrayGetValueAtIndex(originalListRef, i)))
+	}
+
+	newListRef := C.CFArrayCreate(C.kCFAllocatorDefault, &newList[0], C.CFIndex(len(newList)), &C.kCFTypeArrayCallBacks)
+	defer C.CFRelease(C.CFTypeRef(newListRef))
+
+	if status := C.SecKeychainSetSearchList(newListRef); status != C.errSecSuccess {
+		t.Fatalf("SecKeychainSetSearchList: error (OSStatus): %d", status)
+	}
+
+	return func() {
+		if status := C.SecKeychainSetSearchList(originalListRef); status != C.errSecSuccess {
+			t.Errorf("failed to restore the original Keychain search list: OSStatus %d", status)
+		}
+		C.CFRelease(C.CFTypeRef(originalListRef))
+	}
+}
+
+// importTestIdentity generates a self-signed identity with the given
+// Common Name and imports it (as PKCS12) into keychainRef.
+func importTestIdentity(t *testing.T, keychainRef C.SecKeychainRef, commonName string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create test certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(derBytes)
+	if err != nil {
+		t.Fatalf("failed to parse test certificate: %s", err)
+	}
+
+	p12Bytes, err := pkcs12.Encode(rand.Reader, key, cert, nil, "")
+	if err != nil {
+		t.Fatalf("failed to PKCS12-encode test identity: %s", err)
+	}
+
+	p12DataRef := C.CFDataCreate(C.kCFAllocatorDefault, (*C.UInt8)(unsafe.Pointer(&p12Bytes[0])), C.CFIndex(len(p12Bytes)))
+	defer C.CFRelease(C.CFTypeRef(p12DataRef))
+
+	passphraseCString := C.CString("")
+	defer C.free(unsafe.Pointer(passphraseCString))
+
+	var importParams C.SecItemImportExportKeyParameters
+	importParams.passphrase = (C.CFTypeRef)(convertCStringToCFString(passphraseCString))
+	importParams.version = C.SEC_KEY_IMPORT_EXPORT_PARAMS_VERSION
+
+	inputFormat := C.kSecFormatPKCS12
+	itemType := C.kSecItemTypeAggregate
+	status := C.SecItemImport(p12DataRef, nil, &inputFormat, &itemType, 0, &importParams, keychainRef, nil)
+	if status != C.errSecSuccess {
+		t.Fatalf("SecItemImport: error (OSStatus): %d", status)
+	}
+}
+
+// TestFindIdentity_SingleMatch is a regression test for the off-by-one bug
+// where `for i := identitiesCount - 1; i > 0; i--` silently skipped index 0,
+// making a single-identity Keychain report "no Identity found".
+func TestFindIdentity_SingleMatch(t *testing.T) {
+	keychainRef, cleanup := newTestKeychain(t)
+	defer cleanup()
+
+	importTestIdentity(t, keychainRef, "codesigndoc-test-single")
+
+	matches, err := FindIdentity(IdentityMatch{Label: "codesigndoc-test-single"})
+	if err != nil {
+		t.Fatalf("FindIdentity returned an error: %s", err)
+	}
+	defer ReleaseIdentities(matches)
+
+	if len(matches) != 1 {
+		t.Fatalf("expected exactly 1 matching Identity, got: %d", len(matches))
+	}
+}
+
+// TestFindIdentity_DisambiguateBySHA1 verifies that two Identities sharing a
+// label can be told apart by SHA1Fingerprint.
+func TestFindIdentity_DisambiguateBySHA1(t *testing.T) {
+	keychainRef, cleanup := newTestKeychain(t)
+	defer cleanup()
+
+	importTestIdentity(t, keychainRef, "codesigndoc-test-dup")
+	importTestIdentity(t, keychainRef, "codesigndoc-test-dup")
+
+	matches, err := FindIdentity(IdentityMatch{Label: "codesigndoc-test-dup"})
+	if err != nil {
+		t.Fatalf("FindIdentity returned an error: %s", err)
+	}
+	defer ReleaseIdentities(matches)
+
+	if len(matches) != 2 {
+		t.Fatalf("expected exactly 2 matching Identities, got: %d", len(matches))
+	}
+
+	single, err := FindIdentity(IdentityMatch{Label: "codesigndoc-test-dup", SHA1Fingerprint: matches[0].SHA1Fingerprint})
+	if err != nil {
+		t.Fatalf("FindIdentity (by label+SHA1) returned an error: %s", err)
+	}
+	defer ReleaseIdentities(single)
+
+	if len(single) != 1 || single[0].SHA1Fingerprint != matches[0].SHA1Fingerprint {
+		t.Fatalf("expected to disambiguate down to the single matching SHA1Fingerprint, got: %+v", single)
+	}
+}
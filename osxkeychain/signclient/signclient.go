@@ -0,0 +1,118 @@
+// Package signclient implements crypto.Signer against a running
+// "codesigndoc serve" signing proxy, so callers (xcodebuild wrappers,
+// custom build tooling) can sign with a Keychain Identity without its
+// private key ever being materialized on disk.
+package signclient
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/bitrise-tools/codesigndoc/signproto"
+)
+
+// Signer implements crypto.Signer by delegating Sign calls to a
+// "codesigndoc serve" instance over its Unix-socket RPC.
+type Signer struct {
+	SocketPath   string
+	IdentitySHA1 string
+
+	publicKey crypto.PublicKey
+}
+
+// New connects to the signing proxy listening at socketPath and returns a
+// Signer for the Identity with the given SHA-1 fingerprint.
+func New(socketPath string, identitySHA1 string) (*Signer, error) {
+	s := &Signer{SocketPath: socketPath, IdentitySHA1: identitySHA1}
+
+	resp, err := s.call(signproto.Request{Op: signproto.OpGetCertificateChain, IdentitySHA1: identitySHA1})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.CertificateChain) < 1 {
+		return nil, fmt.Errorf("signing proxy returned no certificate chain for Identity: %s", identitySHA1)
+	}
+
+	cert, err := x509.ParseCertificate(resp.CertificateChain[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse leaf certificate returned by signing proxy: %s", err)
+	}
+	s.publicKey = cert.PublicKey
+
+	return s, nil
+}
+
+// Public returns the Signer's public key.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign signs digest (already hashed with opts.HashFunc()) via the signing
+// proxy. The private key never leaves the remote Keychain.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hashAlg, err := signAlgorithmName(s.publicKey, opts.HashFunc())
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.call(signproto.Request{
+		Op:           signproto.OpSignDigest,
+		IdentitySHA1: s.IdentitySHA1,
+		Digest:       digest,
+		HashAlg:      hashAlg,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Signature, nil
+}
+
+func signAlgorithmName(pub crypto.PublicKey, hash crypto.Hash) (string, error) {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		switch hash {
+		case crypto.SHA256:
+			return "RSA-PKCS1v15-SHA256", nil
+		case crypto.SHA384:
+			return "RSA-PKCS1v15-SHA384", nil
+		case crypto.SHA512:
+			return "RSA-PKCS1v15-SHA512", nil
+		}
+	case *ecdsa.PublicKey:
+		switch hash {
+		case crypto.SHA256:
+			return "ECDSA-SHA256", nil
+		case crypto.SHA384:
+			return "ECDSA-SHA384", nil
+		}
+	}
+	return "", fmt.Errorf("unsupported key type/hash combination for signing: %T / %s", pub, hash)
+}
+
+func (s *Signer) call(req signproto.Request) (signproto.Response, error) {
+	conn, err := net.Dial("unix", s.SocketPath)
+	if err != nil {
+		return signproto.Response{}, fmt.Errorf("failed to connect to signing proxy at %s: %s", s.SocketPath, err)
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return signproto.Response{}, fmt.Errorf("failed to send request to signing proxy: %s", err)
+	}
+
+	var resp signproto.Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return signproto.Response{}, fmt.Errorf("failed to read response from signing proxy: %s", err)
+	}
+	if resp.Error != "" {
+		return signproto.Response{}, errors.New(resp.Error)
+	}
+	return resp, nil
+}
@@ -0,0 +1,212 @@
+package osxkeychain
+
+/*
+#cgo CFLAGS: -mmacosx-version-min=10.7 -D__MAC_OS_X_VERSION_MAX_ALLOWED=1060
+#cgo LDFLAGS: -framework CoreFoundation -framework Security
+#include <stdlib.h>
+#include <CoreFoundation/CoreFoundation.h>
+#include <Security/Security.h>
+*/
+import "C"
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+	"unsafe"
+)
+
+// SignAlgorithm identifies a signing digest algorithm/padding combination
+// supported by SignDigest.
+type SignAlgorithm string
+
+// Supported SignAlgorithm values.
+const (
+	AlgRSAPKCS1v15SHA256 SignAlgorithm = "RSA-PKCS1v15-SHA256"
+	AlgRSAPKCS1v15SHA384 SignAlgorithm = "RSA-PKCS1v15-SHA384"
+	AlgRSAPKCS1v15SHA512 SignAlgorithm = "RSA-PKCS1v15-SHA512"
+	AlgECDSASHA256       SignAlgorithm = "ECDSA-SHA256"
+	AlgECDSASHA384       SignAlgorithm = "ECDSA-SHA384"
+)
+
+func secKeyAlgorithm(alg SignAlgorithm) (C.SecKeyAlgorithm, error) {
+	switch alg {
+	case AlgRSAPKCS1v15SHA256:
+		return C.kSecKeyAlgorithmRSASignatureDigestPKCS1v15SHA256, nil
+	case AlgRSAPKCS1v15SHA384:
+		return C.kSecKeyAlgorithmRSASignatureDigestPKCS1v15SHA384, nil
+	case AlgRSAPKCS1v15SHA512:
+		return C.kSecKeyAlgorithmRSASignatureDigestPKCS1v15SHA512, nil
+	case AlgECDSASHA256:
+		return C.kSecKeyAlgorithmECDSASignatureDigestX962SHA256, nil
+	case AlgECDSASHA384:
+		return C.kSecKeyAlgorithmECDSASignatureDigestX962SHA384, nil
+	default:
+		return nil, fmt.Errorf("unsupported SignAlgorithm: %s", alg)
+	}
+}
+
+// SignDigest signs digest (an already-hashed message) with the private key
+// backing identityRef, via SecIdentityCopyPrivateKey + SecKeyCreateSignature.
+// The private key material is never copied out of the Keychain/Secure Enclave.
+func SignDigest(identityRef C.CFTypeRef, digest []byte, alg SignAlgorithm) ([]byte, error) {
+	if len(digest) == 0 {
+		return nil, errors.New("SignDigest: empty digest")
+	}
+
+	keyAlgorithm, err := secKeyAlgorithm(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	var privateKeyRef C.SecKeyRef
+	status := C.SecIdentityCopyPrivateKey(C.SecIdentityRef(unsafe.Pointer(identityRef)), &privateKeyRef)
+	if status != C.errSecSuccess {
+		return nil, fmt.Errorf("SecIdentityCopyPrivateKey: error (OSStatus): %d", status)
+	}
+	defer C.CFRelease(C.CFTypeRef(privateKeyRef))
+
+	digestDataRef := C.CFDataCreate(C.kCFAllocatorDefault, (*C.UInt8)(unsafe.Pointer(&digest[0])), C.CFIndex(len(digest)))
+	defer C.CFRelease(C.CFTypeRef(digestDataRef))
+
+	var cfErr C.CFErrorRef
+	sigDataRef := C.SecKeyCreateSignature(privateKeyRef, keyAlgorithm, digestDataRef, &cfErr)
+	if sigDataRef == nil {
+		if cfErr != nil {
+			defer C.CFRelease(C.CFTypeRef(cfErr))
+		}
+		return nil, errors.New("SecKeyCreateSignature: failed to create signature")
+	}
+	defer C.CFRelease(C.CFTypeRef(sigDataRef))
+
+	return C.GoBytes(unsafe.Pointer(C.CFDataGetBytePtr(sigDataRef)), (C.int)(C.CFDataGetLength(sigDataRef))), nil
+}
+
+// PublicKeyDER returns the DER (SubjectPublicKeyInfo) encoding of the public
+// key backing identityRef, derived from its certificate.
+func PublicKeyDER(identityRef C.CFTypeRef) ([]byte, error) {
+	certDER, err := certificateDER(identityRef)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, fmt.Errorf("PublicKeyDER: failed to parse certificate: %s", err)
+	}
+	return x509.MarshalPKIXPublicKey(cert.PublicKey)
+}
+
+// CertificateChainDER returns the DER encoding of the certificate chain
+// backing identityRef. Only the leaf (identity) certificate is resolved -
+// looking up intermediate/root certificates from the Keychain is not
+// implemented, so callers should fetch those from the same source they'd
+// use when signing with an exported .p12.
+func CertificateChainDER(identityRef C.CFTypeRef) ([][]byte, error) {
+	leafDER, err := certificateDER(identityRef)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{leafDER}, nil
+}
+
+// IdentityInfo is a Keychain Identity's summary, as enumerated by
+// ListIdentities/FindIdentity. The caller is responsible for releasing Ref
+// (via ReleaseRef, or ReleaseIdentities for a whole slice) once done with it.
+type IdentityInfo struct {
+	Ref               C.CFTypeRef
+	Label             string
+	CommonName        string
+	Issuer            string
+	SHA1Fingerprint   string
+	SHA256Fingerprint string
+	PublicKeyDER      []byte
+	NotBefore         time.Time
+	NotAfter          time.Time
+}
+
+// ReleaseIdentities releases every IdentityInfo's Ref.
+func ReleaseIdentities(identities []IdentityInfo) {
+	for _, identity := range identities {
+		ReleaseRef(identity.Ref)
+	}
+}
+
+// ListIdentities enumerates every Identity (certificate + private key pair)
+// across the user's Keychains - used by the "codesigndoc serve" signing
+// proxy to advertise what it can sign with.
+func ListIdentities() ([]IdentityInfo, error) {
+	queryDict := C.CFDictionaryCreateMutable(nil, 0, nil, nil)
+	defer C.CFRelease(C.CFTypeRef(queryDict))
+	C.CFDictionaryAddValue(queryDict, unsafe.Pointer(C.kSecClass), unsafe.Pointer(C.kSecClassIdentity))
+	C.CFDictionaryAddValue(queryDict, unsafe.Pointer(C.kSecMatchLimit), unsafe.Pointer(C.kSecMatchLimitAll))
+	C.CFDictionaryAddValue(queryDict, unsafe.Pointer(C.kSecReturnAttributes), unsafe.Pointer(C.kCFBooleanTrue))
+	C.CFDictionaryAddValue(queryDict, unsafe.Pointer(C.kSecReturnRef), unsafe.Pointer(C.kCFBooleanTrue))
+
+	var resultRefs C.CFTypeRef
+	status := C.SecItemCopyMatching(queryDict, &resultRefs)
+	if status == C.errSecItemNotFound {
+		return []IdentityInfo{}, nil
+	}
+	if status != C.errSecSuccess {
+		return nil, fmt.Errorf("Failed to call SecItemCopyMatch - OSStatus: %d", status)
+	}
+	defer C.CFRelease(C.CFTypeRef(resultRefs))
+
+	identitiesArrRef := C.CFArrayRef(resultRefs)
+	identitiesCount := C.CFArrayGetCount(identitiesArrRef)
+
+	infos := make([]IdentityInfo, 0, int(identitiesCount))
+	for i := C.CFIndex(0); i < identitiesCount; i++ {
+		aIdentityDictRef := C.CFDictionaryRef(C.CFArrayGetValueAtIndex(identitiesArrRef, i))
+
+		lablCString := C.CString("labl")
+		defer C.free(unsafe.Pointer(lablCString))
+		vrefCString := C.CString("v_Ref")
+		defer C.free(unsafe.Pointer(vrefCString))
+
+		labl, err := getCFDictValueUTF8String(aIdentityDictRef, C.CFTypeRef(convertCStringToCFString(lablCString)))
+		if err != nil {
+			return nil, fmt.Errorf("ListIdentities: failed to get 'labl' property: %s", err)
+		}
+
+		vrefRef, err := getCFDictValueRef(aIdentityDictRef, C.CFTypeRef(convertCStringToCFString(vrefCString)))
+		if err != nil {
+			return nil, fmt.Errorf("ListIdentities: failed to get 'v_Ref' property: %s", err)
+		}
+		vrefRef = C.CFRetain(vrefRef)
+
+		der, err := certificateDER(vrefRef)
+		if err != nil {
+			return nil, fmt.Errorf("ListIdentities: failed to determine certificate: %s", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("ListIdentities: failed to parse certificate: %s", err)
+		}
+		sha1Sum := sha1.Sum(der)
+		sha256Sum := sha256.Sum256(der)
+
+		pubKeyDER, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("ListIdentities: failed to determine public key: %s", err)
+		}
+
+		infos = append(infos, IdentityInfo{
+			Ref:               vrefRef,
+			Label:             labl,
+			CommonName:        cert.Subject.CommonName,
+			Issuer:            cert.Issuer.CommonName,
+			SHA1Fingerprint:   hex.EncodeToString(sha1Sum[:]),
+			SHA256Fingerprint: hex.EncodeToString(sha256Sum[:]),
+			PublicKeyDER:      pubKeyDER,
+			NotBefore:         cert.NotBefore,
+			NotAfter:          cert.NotAfter,
+		})
+	}
+
+	return infos, nil
+}
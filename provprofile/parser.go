@@ -0,0 +1,133 @@
+package provprofile
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/fullsailor/pkcs7"
+	"howett.net/plist"
+)
+
+// ProfileInfo is the structured content of a .mobileprovision file, decoded
+// from the plist enclosed in its CMS/PKCS7 envelope.
+type ProfileInfo struct {
+	UUID                  string
+	Name                  string
+	TeamName              string
+	TeamIdentifier        []string
+	AppIDName             string
+	ApplicationIdentifier string
+	Entitlements          map[string]interface{}
+	ProvisionedDevices    []string
+	ProvisionsAllDevices  bool
+	ExpirationDate        time.Time
+	CreationDate          time.Time
+	Platform              []string
+	// DeveloperCertificates holds the raw DER bytes of every embedded
+	// developer certificate, in the order they appear in the profile.
+	DeveloperCertificates [][]byte
+}
+
+// rawProfilePlist mirrors the subset of a .mobileprovision's plist keys
+// we care about.
+type rawProfilePlist struct {
+	UUID                  string                 `plist:"UUID"`
+	Name                  string                 `plist:"Name"`
+	TeamName              string                 `plist:"TeamName"`
+	TeamIdentifier        []string               `plist:"TeamIdentifier"`
+	AppIDName             string                 `plist:"AppIDName"`
+	Entitlements          map[string]interface{} `plist:"Entitlements"`
+	ProvisionedDevices    []string               `plist:"ProvisionedDevices"`
+	ProvisionsAllDevices  bool                   `plist:"ProvisionsAllDevices"`
+	ExpirationDate        time.Time              `plist:"ExpirationDate"`
+	CreationDate          time.Time              `plist:"CreationDate"`
+	Platform              []string               `plist:"Platform"`
+	DeveloperCertificates [][]byte               `plist:"DeveloperCertificates"`
+}
+
+// ParseProfile reads the provisioning profile at filePth, strips its
+// CMS/PKCS7 envelope and decodes the enclosed plist into a ProfileInfo.
+func ParseProfile(filePth string) (ProfileInfo, error) {
+	rawContent, err := ioutil.ReadFile(filePth)
+	if err != nil {
+		return ProfileInfo{}, fmt.Errorf("failed to read provisioning profile (%s): %s", filePth, err)
+	}
+
+	p7, err := pkcs7.Parse(rawContent)
+	if err != nil {
+		return ProfileInfo{}, fmt.Errorf("failed to parse CMS/PKCS7 envelope of provisioning profile (%s): %s", filePth, err)
+	}
+
+	var raw rawProfilePlist
+	if _, err := plist.Unmarshal(p7.Content, &raw); err != nil {
+		return ProfileInfo{}, fmt.Errorf("failed to decode plist content of provisioning profile (%s): %s", filePth, err)
+	}
+
+	appID, _ := raw.Entitlements["application-identifier"].(string)
+
+	return ProfileInfo{
+		UUID:                  raw.UUID,
+		Name:                  raw.Name,
+		TeamName:              raw.TeamName,
+		TeamIdentifier:        raw.TeamIdentifier,
+		AppIDName:             raw.AppIDName,
+		ApplicationIdentifier: appID,
+		Entitlements:          raw.Entitlements,
+		ProvisionedDevices:    raw.ProvisionedDevices,
+		ProvisionsAllDevices:  raw.ProvisionsAllDevices,
+		ExpirationDate:        raw.ExpirationDate,
+		CreationDate:          raw.CreationDate,
+		Platform:              raw.Platform,
+		DeveloperCertificates: raw.DeveloperCertificates,
+	}, nil
+}
+
+// CertificateInfo is a summary of a single X.509 certificate embedded in a
+// provisioning profile's DeveloperCertificates array.
+type CertificateInfo struct {
+	CommonName        string
+	Issuer            string
+	NotBefore         time.Time
+	NotAfter          time.Time
+	SHA1Fingerprint   string
+	SHA256Fingerprint string
+}
+
+// Certificates parses every DeveloperCertificates DER entry and returns its
+// CertificateInfo, in the same order they appear in the profile.
+func (info ProfileInfo) Certificates() ([]CertificateInfo, error) {
+	certs := make([]CertificateInfo, 0, len(info.DeveloperCertificates))
+	for _, der := range info.DeveloperCertificates {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse developer certificate embedded in profile %s: %s", info.Name, err)
+		}
+
+		sha1Sum := sha1.Sum(der)
+		sha256Sum := sha256.Sum256(der)
+		certs = append(certs, CertificateInfo{
+			CommonName:        cert.Subject.CommonName,
+			Issuer:            cert.Issuer.CommonName,
+			NotBefore:         cert.NotBefore,
+			NotAfter:          cert.NotAfter,
+			SHA1Fingerprint:   hex.EncodeToString(sha1Sum[:]),
+			SHA256Fingerprint: hex.EncodeToString(sha256Sum[:]),
+		})
+	}
+	return certs, nil
+}
+
+// IsExpired reports whether the profile's ExpirationDate is already in the past.
+func (info ProfileInfo) IsExpired() bool {
+	return info.ExpirationDate.Before(time.Now())
+}
+
+// ExpiresWithin reports whether the profile's ExpirationDate falls within d from now.
+func (info ProfileInfo) ExpiresWithin(d time.Duration) bool {
+	return info.ExpirationDate.Before(time.Now().Add(d))
+}
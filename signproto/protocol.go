@@ -0,0 +1,44 @@
+// Package signproto defines the JSON wire protocol spoken between
+// "codesigndoc serve" and its clients (e.g. osxkeychain/signclient) over
+// the local Unix-socket signing proxy: one JSON Request, one JSON Response,
+// per connection.
+package signproto
+
+// Op identifies which signing-proxy operation a Request performs.
+type Op string
+
+// Supported Ops.
+const (
+	OpListIdentities      Op = "ListIdentities"
+	OpSignDigest          Op = "SignDigest"
+	OpGetCertificateChain Op = "GetCertificateChain"
+)
+
+// Request is a single signing-proxy RPC call.
+type Request struct {
+	Op Op `json:"op"`
+
+	// IdentitySHA1 selects the Identity for SignDigest/GetCertificateChain.
+	IdentitySHA1 string `json:"identity_sha1,omitempty"`
+	// Digest is the already-hashed message to sign, for SignDigest.
+	Digest []byte `json:"digest,omitempty"`
+	// HashAlg is one of the osxkeychain.SignAlgorithm values, for SignDigest.
+	HashAlg string `json:"hash_alg,omitempty"`
+}
+
+// IdentitySummary is a single entry of a ListIdentities Response.
+type IdentitySummary struct {
+	Label           string `json:"label"`
+	SHA1Fingerprint string `json:"sha1_fingerprint"`
+	PublicKeyDER    []byte `json:"public_key_der"`
+}
+
+// Response is a signing-proxy RPC reply. Error is non-empty on failure, in
+// which case the other fields should be ignored.
+type Response struct {
+	Error string `json:"error,omitempty"`
+
+	Identities       []IdentitySummary `json:"identities,omitempty"`
+	Signature        []byte            `json:"signature,omitempty"`
+	CertificateChain [][]byte          `json:"certificate_chain,omitempty"`
+}
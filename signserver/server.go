@@ -0,0 +1,159 @@
+// Package signserver implements the server side of the "codesigndoc serve"
+// signing proxy: a local Unix-socket RPC that answers signproto Requests
+// using Identities already present in the macOS Keychain, so their private
+// keys never have to be exported to a .p12 on disk.
+package signserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/bitrise-tools/codesigndoc/osxkeychain"
+	"github.com/bitrise-tools/codesigndoc/signproto"
+)
+
+// DefaultSocketPath returns the default Unix socket path "codesigndoc
+// serve" listens on: ~/.codesigndoc/signer.sock.
+func DefaultSocketPath() (string, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine current user's home directory: %s", err)
+	}
+	return filepath.Join(usr.HomeDir, ".codesigndoc", "signer.sock"), nil
+}
+
+// Serve listens on socketPath (a Unix domain socket) and answers
+// signproto Requests using the Keychain's Identities. It blocks until the
+// listener fails.
+func Serve(socketPath string) error {
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0700); err != nil {
+		return fmt.Errorf("failed to create socket directory: %s", err)
+	}
+	if err := os.RemoveAll(socketPath); err != nil {
+		return fmt.Errorf("failed to remove stale socket at %s: %s", socketPath, err)
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %s", socketPath, err)
+	}
+	defer listener.Close()
+
+	log.Infof("codesigndoc signing proxy listening on: %s", socketPath)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return fmt.Errorf("failed to accept connection: %s", err)
+		}
+		go handleConn(conn)
+	}
+}
+
+func handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	var req signproto.Request
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		log.Errorf("signing proxy: failed to decode request: %s", err)
+		return
+	}
+
+	resp := handle(req)
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		log.Errorf("signing proxy: failed to encode response: %s", err)
+	}
+}
+
+func handle(req signproto.Request) signproto.Response {
+	switch req.Op {
+	case signproto.OpListIdentities:
+		return handleListIdentities()
+	case signproto.OpSignDigest:
+		return handleSignDigest(req)
+	case signproto.OpGetCertificateChain:
+		return handleGetCertificateChain(req)
+	default:
+		return signproto.Response{Error: fmt.Sprintf("unknown op: %s", req.Op)}
+	}
+}
+
+func handleListIdentities() signproto.Response {
+	identities, err := osxkeychain.ListIdentities()
+	if err != nil {
+		return signproto.Response{Error: err.Error()}
+	}
+	defer func() {
+		for _, identity := range identities {
+			osxkeychain.ReleaseRef(identity.Ref)
+		}
+	}()
+
+	summaries := make([]signproto.IdentitySummary, 0, len(identities))
+	for _, identity := range identities {
+		summaries = append(summaries, signproto.IdentitySummary{
+			Label:           identity.Label,
+			SHA1Fingerprint: identity.SHA1Fingerprint,
+			PublicKeyDER:    identity.PublicKeyDER,
+		})
+	}
+	return signproto.Response{Identities: summaries}
+}
+
+func handleSignDigest(req signproto.Request) signproto.Response {
+	identity, err := findIdentityByFingerprint(req.IdentitySHA1)
+	if err != nil {
+		return signproto.Response{Error: err.Error()}
+	}
+	defer osxkeychain.ReleaseRef(identity.Ref)
+
+	signature, err := osxkeychain.SignDigest(identity.Ref, req.Digest, osxkeychain.SignAlgorithm(req.HashAlg))
+	if err != nil {
+		return signproto.Response{Error: err.Error()}
+	}
+	return signproto.Response{Signature: signature}
+}
+
+func handleGetCertificateChain(req signproto.Request) signproto.Response {
+	identity, err := findIdentityByFingerprint(req.IdentitySHA1)
+	if err != nil {
+		return signproto.Response{Error: err.Error()}
+	}
+	defer osxkeychain.ReleaseRef(identity.Ref)
+
+	chain, err := osxkeychain.CertificateChainDER(identity.Ref)
+	if err != nil {
+		return signproto.Response{Error: err.Error()}
+	}
+	return signproto.Response{CertificateChain: chain}
+}
+
+// findIdentityByFingerprint returns the single Identity matching
+// sha1Fingerprint, releasing every other enumerated Identity's ref along
+// the way. The caller owns the returned Identity's Ref.
+func findIdentityByFingerprint(sha1Fingerprint string) (osxkeychain.IdentityInfo, error) {
+	identities, err := osxkeychain.ListIdentities()
+	if err != nil {
+		return osxkeychain.IdentityInfo{}, err
+	}
+
+	var match *osxkeychain.IdentityInfo
+	for i, identity := range identities {
+		if match == nil && strings.EqualFold(identity.SHA1Fingerprint, sha1Fingerprint) {
+			match = &identities[i]
+			continue
+		}
+		osxkeychain.ReleaseRef(identity.Ref)
+	}
+	if match == nil {
+		return osxkeychain.IdentityInfo{}, fmt.Errorf("no Identity found with SHA-1 fingerprint: %s", sha1Fingerprint)
+	}
+	return *match, nil
+}